@@ -0,0 +1,44 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/oauthutil"
+)
+
+// getConn sets up a GCS connection using the user's default application
+// credentials.
+func getConn() (conn gcs.Conn, err error) {
+	tokenSrc, err := oauthutil.DefaultTokenSource(gcs.Scope_FullControl)
+	if err != nil {
+		err = fmt.Errorf("DefaultTokenSource: %v", err)
+		return
+	}
+
+	cfg := &gcs.ConnConfig{
+		TokenSource: tokenSrc,
+	}
+
+	conn, err = gcs.NewConn(cfg)
+	if err != nil {
+		err = fmt.Errorf("gcs.NewConn: %v", err)
+		return
+	}
+
+	return
+}
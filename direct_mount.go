@@ -0,0 +1,65 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// escapeFsNameOption escapes a value destined for the fsname= mount option
+// so that it survives being embedded in the comma-separated -o option
+// string that jacobsa/fuse hands to the kernel. Both the field separator
+// (',') and the escape character itself ('\') must be escaped; bucket
+// names are user-controlled and may legally contain either.
+func escapeFsNameOption(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `,`, `\,`, -1)
+	return s
+}
+
+// openDevFuse opens the kernel FUSE control device directly, the way
+// fusermount(1) does on gcsfuse's behalf for a normal mount. Opening it
+// ourselves lets --direct-mount fail fast with a clear error in containers
+// and minimal images where /dev/fuse is missing or inaccessible, rather
+// than surfacing an opaque failure from fusermount.
+//
+// Note that jacobsa/fuse's public API has no way to accept an
+// already-established kernel connection, so this is as far as "direct
+// mount" goes today: it's a preflight check, not a replacement for the
+// fusermount(1)-mediated mount fuse.Mount performs.
+func openDevFuse() (f *os.File, err error) {
+	fd, err := unix.Open("/dev/fuse", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		err = fmt.Errorf("open /dev/fuse: %v", err)
+		return
+	}
+
+	f = os.NewFile(uintptr(fd), "/dev/fuse")
+	return
+}
+
+// buildMountData constructs the kernel mount-data string the Linux FUSE
+// driver expects alongside a freshly-opened /dev/fuse descriptor, e.g.
+// "fd=7,rootmode=40755,user_id=0,group_id=0". This is the same string
+// fusermount(1) builds before calling mount(2) on gcsfuse's behalf.
+func buildMountData(fd uintptr, rootMode uint32) string {
+	return fmt.Sprintf(
+		"fd=%d,rootmode=%o,user_id=%d,group_id=%d",
+		fd, rootMode, os.Getuid(), os.Getgid())
+}
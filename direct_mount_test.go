@@ -0,0 +1,73 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type EscapeFsNameOptionTest struct {
+}
+
+func init() { RegisterTestSuite(&EscapeFsNameOptionTest{}) }
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *EscapeFsNameOptionTest) NoSpecialCharacters() {
+	ExpectEq("foo", escapeFsNameOption("foo"))
+}
+
+func (t *EscapeFsNameOptionTest) Comma() {
+	ExpectEq(`foo\,bar`, escapeFsNameOption("foo,bar"))
+}
+
+func (t *EscapeFsNameOptionTest) Backslash() {
+	ExpectEq(`foo\\bar`, escapeFsNameOption(`foo\bar`))
+}
+
+func (t *EscapeFsNameOptionTest) BackslashBeforeComma() {
+	ExpectEq(`foo\\\,bar`, escapeFsNameOption(`foo\,bar`))
+}
+
+////////////////////////////////////////////////////////////////////////
+// buildMountData
+////////////////////////////////////////////////////////////////////////
+
+type BuildMountDataTest struct {
+}
+
+func init() { RegisterTestSuite(&BuildMountDataTest{}) }
+
+func (t *BuildMountDataTest) IncludesFdAndRootMode() {
+	got := buildMountData(7, 0040755)
+	ExpectThat(got, HasSubstr("fd=7,"))
+	ExpectThat(got, HasSubstr(",rootmode=40755,"))
+}
+
+func (t *BuildMountDataTest) IncludesCallingUserAndGroup() {
+	got := buildMountData(3, 0040755)
+	ExpectThat(got, HasSubstr(fmt.Sprintf("user_id=%d,", os.Getuid())))
+	ExpectThat(got, HasSubstr(fmt.Sprintf("group_id=%d", os.Getgid())))
+}
@@ -0,0 +1,105 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+)
+
+// flagStorage holds the values of the command-line flags accepted by the
+// gcsfuse binary, after parsing.
+type flagStorage struct {
+	// Throttling. Zero means unlimited.
+	MaxReadBytesPerSec  int64
+	MaxWriteBytesPerSec int64
+
+	// DebugAddr, if non-empty, has gcsfuse serve the /ratestats debug
+	// endpoint (and any other debug handlers) at this address, e.g.
+	// "localhost:6060". Left empty, no debug server is started.
+	DebugAddr string
+
+	// Tuning. MaxWriteKB is the largest kernel-to-userspace write size to
+	// negotiate with the kernel, in KiB.
+	MaxWriteKB int
+
+	// DirectMount, if set, has gcsfuse open /dev/fuse directly and confirm
+	// it's usable before mounting, so that containers and minimal images
+	// missing it (or lacking permission to use it) fail fast with a clear
+	// error. The mount itself still goes through fusermount(1): jacobsa/fuse
+	// has no public way to hand it an already-established kernel connection.
+	DirectMount bool
+
+	// Prefetch is the raw --prefetch flag value, e.g. "none",
+	// "readahead=4", "manifest=/path/to/list", or "access-log=/path/to/log".
+	// See the prefetch package for how it's interpreted.
+	Prefetch string
+}
+
+// populateFlags registers the gcsfuse flags on the supplied flag set and
+// returns a flagStorage that will be filled in once the flag set is parsed.
+func populateFlags(flagSet *flag.FlagSet) (flags *flagStorage) {
+	flags = new(flagStorage)
+
+	flagSet.Int64Var(
+		&flags.MaxReadBytesPerSec,
+		"max-read-bytes-per-sec",
+		0,
+		"If positive, caps the aggregate rate at which bytes are read from "+
+			"GCS objects to this many bytes per second.")
+
+	flagSet.Int64Var(
+		&flags.MaxWriteBytesPerSec,
+		"max-write-bytes-per-sec",
+		0,
+		"If positive, caps the aggregate rate at which bytes are written to "+
+			"GCS objects to this many bytes per second.")
+
+	flagSet.StringVar(
+		&flags.DebugAddr,
+		"debug-addr",
+		"",
+		"If non-empty, an address (e.g. \"localhost:6060\") on which to "+
+			"serve the /ratestats debug endpoint. Left empty, no debug "+
+			"server is started.")
+
+	flagSet.IntVar(
+		&flags.MaxWriteKB,
+		"max-write-kb",
+		1024,
+		"The size, in KiB, of the largest kernel-to-userspace write that "+
+			"gcsfuse will negotiate with the kernel. Raising this above the "+
+			"historical default of 128 reduces the number of round trips "+
+			"needed to stream large GCS objects, on kernels that support it.")
+
+	flagSet.BoolVar(
+		&flags.DirectMount,
+		"direct-mount",
+		false,
+		"Confirm /dev/fuse is directly usable before mounting, so a missing "+
+			"or inaccessible FUSE device fails fast with a clear error "+
+			"instead of an opaque one from fusermount(1).")
+
+	flagSet.StringVar(
+		&flags.Prefetch,
+		"prefetch",
+		"none",
+		"Warm-cache policy for background reads: \"none\" (default), "+
+			"\"readahead=N\" to fetch N chunks past every read, "+
+			"\"manifest=path\" to prefetch a landmark list before the mount "+
+			"completes, or \"access-log=path\" to record accesses for a "+
+			"later manifest-driven run.")
+
+	return
+}
@@ -0,0 +1,77 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"sync"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/timeutil"
+
+	"github.com/ipv1337/gcsfuse/prefetch"
+)
+
+// ServerConfig holds everything the file system needs to serve a single GCS
+// bucket.
+type ServerConfig struct {
+	// A clock used for cache expiry and other timing decisions.
+	Clock timeutil.Clock
+
+	// The bucket to mount.
+	Bucket gcs.Bucket
+
+	// mu guards prefetcher and onWrite, which callers (notably tests) may
+	// set after the file system is already live and serving requests on
+	// other goroutines.
+	mu         sync.Mutex
+	prefetcher *prefetch.Prefetcher
+	onWrite    func(n int)
+}
+
+// SetPrefetcher installs pf as the prefetcher consulted on reads, replacing
+// any previous one. It is safe to call at any time, including after the
+// file system built from cfg is already mounted and serving requests.
+func (cfg *ServerConfig) SetPrefetcher(pf *prefetch.Prefetcher) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.prefetcher = pf
+}
+
+// Prefetcher returns the prefetcher most recently installed with
+// SetPrefetcher, or nil if none has been.
+func (cfg *ServerConfig) Prefetcher() *prefetch.Prefetcher {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return cfg.prefetcher
+}
+
+// SetOnWrite installs f to be called with the size of every subsequent
+// kernel-to-userspace write, in bytes. It is safe to call at any time,
+// including after the file system built from cfg is already mounted and
+// serving requests. Exists so tests can confirm what chunk size max_write
+// negotiation actually produced.
+func (cfg *ServerConfig) SetOnWrite(f func(n int)) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.onWrite = f
+}
+
+// OnWrite returns the callback most recently installed with SetOnWrite, or
+// nil if none has been.
+func (cfg *ServerConfig) OnWrite() func(n int) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return cfg.onWrite
+}
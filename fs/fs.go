@@ -0,0 +1,551 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fs implements a FUSE file system backed by a GCS bucket. Objects
+// are exposed as files directly in the mount root; there is no support yet
+// for directory-like object name prefixes.
+package fs
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"syscall"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/sys/unix"
+
+	"github.com/ipv1337/gcsfuse/ratelimit"
+)
+
+// ratestatsXattrName is the name scripts can use with getfattr(1) on the
+// mount root to read current rate-limiter throughput.
+const ratestatsXattrName = "user.gcsfuse.ratestats"
+
+// fileSystem is the fuseutil.FileSystem implementation backing a mount. It
+// keeps a flat namespace of inodes rooted at fuseops.RootInodeID, one per
+// GCS object that has been looked up, created, or listed so far.
+type fileSystem struct {
+	fuseutil.NotImplementedFileSystem
+
+	cfg *ServerConfig
+
+	mu         sync.Mutex
+	root       *inode
+	inodes     map[fuseops.InodeID]*inode
+	byName     map[string]fuseops.InodeID
+	nextInode  fuseops.InodeID
+	nextHandle fuseops.HandleID
+	dirHandles map[fuseops.HandleID][]fuseutil.Dirent
+}
+
+// NewFileSystem creates a fuseutil.FileSystem that serves the bucket and
+// options described by cfg.
+func NewFileSystem(cfg *ServerConfig) (fs fuseutil.FileSystem, err error) {
+	root := &inode{id: fuseops.RootInodeID, isDir: true}
+
+	fs = &fileSystem{
+		cfg:        cfg,
+		root:       root,
+		inodes:     map[fuseops.InodeID]*inode{fuseops.RootInodeID: root},
+		byName:     make(map[string]fuseops.InodeID),
+		nextInode:  fuseops.RootInodeID + 1,
+		dirHandles: make(map[fuseops.HandleID][]fuseutil.Dirent),
+	}
+
+	return
+}
+
+func (fs *fileSystem) inodeLocked(id fuseops.InodeID) (in *inode, err error) {
+	in, ok := fs.inodes[id]
+	if !ok {
+		err = fmt.Errorf("unknown inode %v", id)
+	}
+
+	return
+}
+
+// lookUpOrCreateByNameLocked returns the inode for the named GCS object,
+// stat'ing the bucket and registering a new inode on first reference.
+func (fs *fileSystem) lookUpOrCreateByNameLocked(
+	ctx context.Context, name string) (in *inode, err error) {
+	if id, ok := fs.byName[name]; ok {
+		in = fs.inodes[id]
+		return
+	}
+
+	_, err = fs.cfg.Bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: name})
+	if err != nil {
+		return
+	}
+
+	in = fs.registerInodeLocked(name, false)
+	return
+}
+
+// registerInodeLocked allocates and records a new inode for name. The
+// caller must hold fs.mu.
+func (fs *fileSystem) registerInodeLocked(name string, isDir bool) *inode {
+	id := fs.nextInode
+	fs.nextInode++
+
+	in := &inode{id: id, name: name, isDir: isDir}
+	fs.inodes[id] = in
+	fs.byName[name] = id
+
+	return in
+}
+
+func (fs *fileSystem) childEntry(in *inode) fuseops.ChildInodeEntry {
+	return fuseops.ChildInodeEntry{
+		Child:      in.id,
+		Attributes: in.attributes(),
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Inodes
+////////////////////////////////////////////////////////////////////////
+
+func (fs *fileSystem) LookUpInode(
+	ctx context.Context,
+	op *fuseops.LookUpInodeOp) (err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if op.Parent != fuseops.RootInodeID {
+		err = syscall.ENOENT
+		return
+	}
+
+	in, err := fs.lookUpOrCreateByNameLocked(ctx, op.Name)
+	if err != nil {
+		err = syscall.ENOENT
+		return
+	}
+
+	in.mu.Lock()
+	in.lookupCount++
+	in.mu.Unlock()
+
+	op.Entry = fs.childEntry(in)
+	return
+}
+
+func (fs *fileSystem) GetInodeAttributes(
+	ctx context.Context,
+	op *fuseops.GetInodeAttributesOp) (err error) {
+	fs.mu.Lock()
+	in, err := fs.inodeLocked(op.Inode)
+	fs.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	op.Attributes = in.attributes()
+	return
+}
+
+func (fs *fileSystem) SetInodeAttributes(
+	ctx context.Context,
+	op *fuseops.SetInodeAttributesOp) (err error) {
+	fs.mu.Lock()
+	in, err := fs.inodeLocked(op.Inode)
+	fs.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if op.Size != nil && in.content != nil {
+		if err = in.content.Truncate(int64(*op.Size)); err != nil {
+			return
+		}
+		in.size = int64(*op.Size)
+		in.dirty = true
+	}
+
+	op.Attributes = in.attributes()
+	return
+}
+
+func (fs *fileSystem) ForgetInode(
+	ctx context.Context,
+	op *fuseops.ForgetInodeOp) (err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	in, err := fs.inodeLocked(op.Inode)
+	if err != nil {
+		err = nil // the kernel may forget an inode we never saw; ignore
+		return
+	}
+
+	in.mu.Lock()
+	if op.N >= in.lookupCount {
+		in.lookupCount = 0
+	} else {
+		in.lookupCount -= op.N
+	}
+	done := in.lookupCount == 0
+	content := in.content
+	in.content = nil
+	in.mu.Unlock()
+
+	if done && in.id != fuseops.RootInodeID {
+		if content != nil {
+			content.Close()
+		}
+		delete(fs.inodes, in.id)
+		delete(fs.byName, in.name)
+	} else if content != nil {
+		// Not actually forgotten; put the handle back.
+		in.mu.Lock()
+		in.content = content
+		in.mu.Unlock()
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Directories
+////////////////////////////////////////////////////////////////////////
+
+func (fs *fileSystem) OpenDir(
+	ctx context.Context,
+	op *fuseops.OpenDirOp) (err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	in, err := fs.inodeLocked(op.Inode)
+	if err != nil {
+		return
+	}
+
+	if !in.isDir {
+		err = syscall.ENOTDIR
+		return
+	}
+
+	listing, err := fs.cfg.Bucket.ListObjects(ctx, &gcs.ListObjectsRequest{})
+	if err != nil {
+		return
+	}
+
+	var dirents []fuseutil.Dirent
+	offset := fuseops.DirOffset(1)
+	for _, o := range listing.Objects {
+		child, lerr := fs.lookUpOrCreateByNameLocked(ctx, o.Name)
+		if lerr != nil {
+			continue
+		}
+
+		dirents = append(dirents, fuseutil.Dirent{
+			Offset: offset,
+			Inode:  child.id,
+			Name:   o.Name,
+			Type:   fuseutil.DT_File,
+		})
+		offset++
+	}
+
+	fs.nextHandle++
+	op.Handle = fs.nextHandle
+	fs.dirHandles[op.Handle] = dirents
+
+	return
+}
+
+func (fs *fileSystem) ReadDir(
+	ctx context.Context,
+	op *fuseops.ReadDirOp) (err error) {
+	fs.mu.Lock()
+	dirents, ok := fs.dirHandles[op.Handle]
+	fs.mu.Unlock()
+
+	if !ok {
+		err = fmt.Errorf("unknown dir handle %v", op.Handle)
+		return
+	}
+
+	for _, d := range dirents {
+		if d.Offset < op.Offset {
+			continue
+		}
+
+		n := fuseutil.WriteDirent(op.Dst[op.BytesRead:], d)
+		if n == 0 {
+			break
+		}
+
+		op.BytesRead += n
+	}
+
+	return
+}
+
+func (fs *fileSystem) ReleaseDirHandle(
+	ctx context.Context,
+	op *fuseops.ReleaseDirHandleOp) (err error) {
+	fs.mu.Lock()
+	delete(fs.dirHandles, op.Handle)
+	fs.mu.Unlock()
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Files
+////////////////////////////////////////////////////////////////////////
+
+func (fs *fileSystem) CreateFile(
+	ctx context.Context,
+	op *fuseops.CreateFileOp) (err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if op.Parent != fuseops.RootInodeID {
+		err = syscall.ENOENT
+		return
+	}
+
+	in := fs.registerInodeLocked(op.Name, false)
+
+	in.mu.Lock()
+	in.content, err = newTempFile()
+	in.lookupCount++
+	in.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	fs.nextHandle++
+	op.Handle = fs.nextHandle
+	op.Entry = fs.childEntry(in)
+
+	return
+}
+
+func (fs *fileSystem) OpenFile(
+	ctx context.Context,
+	op *fuseops.OpenFileOp) (err error) {
+	fs.mu.Lock()
+	in, err := fs.inodeLocked(op.Inode)
+	fs.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if in.content == nil {
+		in.content, err = newTempFile()
+		if err != nil {
+			return
+		}
+
+		// If the prefetcher already warmed this object in full (e.g. via a
+		// manifest read at mount time), serve the open from that cache
+		// instead of issuing a redundant GCS read.
+		cached := false
+		if pf := fs.cfg.Prefetcher(); pf != nil {
+			if data, ok := pf.TryReadCachedFile(in.name); ok {
+				if _, err = in.content.Write(data); err != nil {
+					return
+				}
+
+				in.size = int64(len(data))
+				cached = true
+			}
+		}
+
+		if !cached {
+			var rc io.ReadCloser
+			rc, err = fs.cfg.Bucket.NewReader(
+				ctx, &gcs.ReadObjectRequest{Name: in.name})
+			if err != nil {
+				return
+			}
+			defer rc.Close()
+
+			var n int64
+			n, err = io.Copy(in.content, rc)
+			if err != nil {
+				return
+			}
+
+			in.size = n
+		}
+	}
+
+	fs.mu.Lock()
+	fs.nextHandle++
+	op.Handle = fs.nextHandle
+	fs.mu.Unlock()
+
+	return
+}
+
+func (fs *fileSystem) ReadFile(
+	ctx context.Context,
+	op *fuseops.ReadFileOp) (err error) {
+	fs.mu.Lock()
+	in, err := fs.inodeLocked(op.Inode)
+	fs.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if in.content == nil {
+		err = fmt.Errorf("read on unopened inode %v", op.Inode)
+		return
+	}
+
+	n, rerr := in.content.ReadAt(op.Dst, op.Offset)
+	op.BytesRead = n
+	if rerr != nil && rerr != io.EOF {
+		err = rerr
+		return
+	}
+
+	if pf := fs.cfg.Prefetcher(); pf != nil {
+		pf.OnRead(in.name, op.Offset, n)
+	}
+
+	return
+}
+
+func (fs *fileSystem) WriteFile(
+	ctx context.Context,
+	op *fuseops.WriteFileOp) (err error) {
+	fs.mu.Lock()
+	in, err := fs.inodeLocked(op.Inode)
+	fs.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if in.content == nil {
+		err = fmt.Errorf("write on unopened inode %v", op.Inode)
+		return
+	}
+
+	n, werr := in.content.WriteAt(op.Data, op.Offset)
+	if werr != nil {
+		err = werr
+		return
+	}
+
+	if end := op.Offset + int64(n); end > in.size {
+		in.size = end
+	}
+	in.dirty = true
+
+	if onWrite := fs.cfg.OnWrite(); onWrite != nil {
+		onWrite(n)
+	}
+
+	return
+}
+
+func (fs *fileSystem) FlushFile(
+	ctx context.Context,
+	op *fuseops.FlushFileOp) (err error) {
+	fs.mu.Lock()
+	in, err := fs.inodeLocked(op.Inode)
+	fs.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if in.content == nil || !in.dirty {
+		return
+	}
+
+	if _, err = in.content.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+
+	_, err = fs.cfg.Bucket.CreateObject(
+		ctx, &gcs.CreateObjectRequest{Name: in.name, Contents: in.content})
+	if err != nil {
+		return
+	}
+
+	in.dirty = false
+	return
+}
+
+func (fs *fileSystem) ReleaseFileHandle(
+	ctx context.Context,
+	op *fuseops.ReleaseFileHandleOp) (err error) {
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Extended attributes
+////////////////////////////////////////////////////////////////////////
+
+// GetXattr serves the synthetic user.gcsfuse.ratestats attribute on the
+// mount root, reporting the current read/write throughput observed by the
+// rate limiter wrapping the bucket, if any. All other inodes and names
+// behave as NotImplementedFileSystem (ENOSYS).
+func (fs *fileSystem) GetXattr(
+	ctx context.Context,
+	op *fuseops.GetXattrOp) (err error) {
+	if op.Inode != fuseops.RootInodeID || op.Name != ratestatsXattrName {
+		err = fs.NotImplementedFileSystem.GetXattr(ctx, op)
+		return
+	}
+
+	src, ok := fs.cfg.Bucket.(ratelimit.StatsSource)
+	if !ok {
+		err = unix.ENODATA
+		return
+	}
+
+	rs := src.ReadStats()
+	ws := src.WriteStats()
+	value := []byte(fmt.Sprintf(
+		"read_bytes=%d read_rate=%.0f read_peak=%.0f "+
+			"write_bytes=%d write_rate=%.0f write_peak=%.0f\n",
+		rs.Bytes, rs.CurrentRate, rs.PeakRate,
+		ws.Bytes, ws.CurrentRate, ws.PeakRate))
+
+	op.BytesRead = len(value)
+	if len(op.Dst) >= len(value) {
+		copy(op.Dst, value)
+	} else if len(op.Dst) != 0 {
+		err = unix.ERANGE
+	}
+
+	return
+}
@@ -0,0 +1,107 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+
+	"github.com/ipv1337/gcsfuse/fs"
+)
+
+func TestFS(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+// fsTest mounts a fs.fileSystem backed by a fake bucket before every test
+// and unmounts it afterward. Every suite in this package embeds it to gain
+// access to the live mount at Dir.
+type fsTest struct {
+	ctx       context.Context
+	clock     timeutil.SimulatedClock
+	serverCfg *fs.ServerConfig
+	mfs       *fuse.MountedFileSystem
+
+	// Dir is the mount point backed by serverCfg's bucket; tests perform
+	// file I/O here.
+	Dir string
+}
+
+var _ SetUpInterface = &fsTest{}
+var _ TearDownInterface = &fsTest{}
+
+func (t *fsTest) SetUp(ti *TestInfo) {
+	var err error
+
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+
+	conn := gcsfake.NewConn(&t.clock)
+	bucket, err := conn.OpenBucket(t.ctx, "some_bucket")
+	AssertEq(nil, err)
+
+	t.serverCfg = &fs.ServerConfig{
+		Clock:  &t.clock,
+		Bucket: bucket,
+	}
+
+	fileSystem, err := fs.NewFileSystem(t.serverCfg)
+	AssertEq(nil, err)
+
+	t.Dir, err = ioutil.TempDir("", "fs_test")
+	AssertEq(nil, err)
+
+	t.mfs, err = fuse.Mount(
+		t.Dir, fuseutil.NewFileSystemServer(fileSystem), &fuse.MountConfig{})
+	AssertEq(nil, err)
+}
+
+func (t *fsTest) TearDown() {
+	// Unmount, retrying on "resource busy" errors as main.go's own tests do.
+	delay := 10 * time.Millisecond
+	for {
+		err := fuse.Unmount(t.Dir)
+		if err == nil {
+			break
+		}
+
+		if strings.Contains(err.Error(), "resource busy") {
+			log.Println("Resource busy error while unmounting; trying again")
+			time.Sleep(delay)
+			delay = time.Duration(1.3 * float64(delay))
+			continue
+		}
+
+		panic(fmt.Sprintf("Unmount: %v", err))
+	}
+
+	AssertEq(nil, t.mfs.Join(t.ctx))
+	AssertEq(nil, os.RemoveAll(t.Dir))
+}
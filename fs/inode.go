@@ -0,0 +1,63 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// inode tracks the in-memory state for a single file or directory. For
+// regular files, content is a local temporary file holding the object's
+// current contents (downloaded lazily on first open, or empty for a file
+// created locally but not yet flushed to GCS).
+type inode struct {
+	id    fuseops.InodeID
+	name  string // GCS object name; unused for the root directory
+	isDir bool
+
+	mu          sync.Mutex
+	content     *os.File
+	size        int64
+	dirty       bool
+	lookupCount uint64
+}
+
+func (in *inode) attributes() fuseops.InodeAttributes {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	mode := os.FileMode(0644)
+	nlink := uint32(1)
+	if in.isDir {
+		mode = os.ModeDir | 0755
+		nlink = 2
+	}
+
+	return fuseops.InodeAttributes{
+		Size:  uint64(in.size),
+		Nlink: nlink,
+		Mode:  mode,
+	}
+}
+
+// newTempFile returns a fresh, empty local file to back an inode's
+// contents.
+func newTempFile() (f *os.File, err error) {
+	return ioutil.TempFile("", "gcsfuse-content-")
+}
@@ -15,19 +15,48 @@
 package fs_test
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"path"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"golang.org/x/net/context"
+
 	"github.com/jacobsa/fuse/fusetesting"
+	"github.com/jacobsa/gcloud/gcs"
 	. "github.com/jacobsa/ogletest"
+
+	"github.com/ipv1337/gcsfuse/prefetch"
 )
 
+// readCountingBucket wraps a gcs.Bucket, counting calls to NewReader so
+// tests can assert deterministically on how many times an object was
+// actually fetched from the backing bucket, rather than comparing wall
+// clock durations.
+type readCountingBucket struct {
+	gcs.Bucket
+
+	reads int64
+}
+
+func (b *readCountingBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	atomic.AddInt64(&b.reads, 1)
+	return b.Bucket.NewReader(ctx, req)
+}
+
+func (b *readCountingBucket) readCount() int64 {
+	return atomic.LoadInt64(&b.reads)
+}
+
 ////////////////////////////////////////////////////////////////////////
 // Helpers
 ////////////////////////////////////////////////////////////////////////
@@ -72,8 +101,7 @@ func (t *StressTest) CreateAndReadManyFilesInParallel() {
 	// Ensure that we get parallelism for this test.
 	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(runtime.NumCPU()))
 
-	// Exercise lease revocation logic.
-	numFiles := 2 * t.serverCfg.TempDirLimitNumFiles
+	const numFiles = 64
 
 	// Choose a bunch of file names.
 	var names []string
@@ -99,6 +127,86 @@ func (t *StressTest) CreateAndReadManyFilesInParallel() {
 		})
 }
 
+func (t *StressTest) CreateAndReadManyFilesInParallel_ManifestPrefetch() {
+	const numObjects = 8
+	const objectSize = 64 * 1024
+
+	contents := make([]byte, objectSize)
+	for i := range contents {
+		contents[i] = byte(i)
+	}
+
+	// Count bucket reads so we can assert deterministically that a warmed
+	// object is served without touching the bucket, instead of comparing
+	// wall clock durations.
+	counting := &readCountingBucket{Bucket: t.serverCfg.Bucket}
+	t.serverCfg.Bucket = counting
+
+	var names []string
+	for i := 0; i < numObjects; i++ {
+		name := fmt.Sprintf("prefetch_%d", i)
+		names = append(names, name)
+
+		_, err := counting.CreateObject(
+			t.ctx,
+			&gcs.CreateObjectRequest{Name: name, Contents: bytes.NewReader(contents)})
+		AssertEq(nil, err)
+	}
+
+	// Write a landmark manifest covering every object except the last,
+	// which stays cold so we have something to contrast the warmed reads
+	// against.
+	warmNames := names[:numObjects-1]
+	coldName := names[numObjects-1]
+
+	manifestPath := path.Join(t.Dir, "manifest.tsv")
+	var manifest bytes.Buffer
+	for _, name := range warmNames {
+		fmt.Fprintf(&manifest, "%s\t0\t%d\n", name, objectSize)
+	}
+	err := ioutil.WriteFile(manifestPath, manifest.Bytes(), 0600)
+	AssertEq(nil, err)
+
+	policy, err := prefetch.ParsePolicy("manifest=" + manifestPath)
+	AssertEq(nil, err)
+
+	pf, err := prefetch.NewPrefetcher(counting, policy, 0)
+	AssertEq(nil, err)
+
+	err = pf.Warm(t.ctx)
+	AssertEq(nil, err)
+
+	// Hand the prefetcher to the mounted file system and see it actually
+	// used: t.serverCfg is the same *ServerConfig the mount was built from,
+	// so the fileSystem serving t.Dir picks this up on its next request.
+	// SetPrefetcher takes cfg's own lock, since the mount is already live
+	// and serving requests on other goroutines by this point.
+	t.serverCfg.SetPrefetcher(pf)
+
+	readsBeforeOpen := counting.readCount()
+
+	// Reading every warmed object through the real mount should be
+	// satisfied entirely out of the prefetcher's cache: no additional
+	// bucket reads.
+	forEachName(
+		warmNames,
+		func(n string) {
+			data, rerr := ioutil.ReadFile(path.Join(t.Dir, n))
+			AssertEq(nil, rerr)
+			AssertTrue(bytes.Equal(contents, data))
+		})
+
+	ExpectEq(readsBeforeOpen, counting.readCount())
+
+	// Reading the cold object through the same mount has to fall through to
+	// the bucket, proving the zero-reads result above isn't just because
+	// nothing was ever read.
+	data, err := ioutil.ReadFile(path.Join(t.Dir, coldName))
+	AssertEq(nil, err)
+	AssertTrue(bytes.Equal(contents, data))
+	ExpectEq(readsBeforeOpen+1, counting.readCount())
+}
+
 func (t *StressTest) TruncateFileManyTimesInParallel() {
 	// Ensure that we get parallelism for this test.
 	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(runtime.NumCPU()))
@@ -169,6 +277,55 @@ func (t *StressTest) CreateInParallel_Exclusive() {
 	fusetesting.RunCreateInParallelTest_Exclusive(t.ctx, t.Dir)
 }
 
+func (t *StressTest) WriteAndReadBackLargeFile() {
+	// Large enough to span several kernel writes regardless of whether
+	// max_write negotiation landed at the historical 128 KiB default or the
+	// raised 1 MiB ceiling.
+	const size = 8 * 1024 * 1024
+
+	// Trace every kernel-to-userspace write so we can confirm what chunk
+	// size max_write negotiation actually produced, rather than just
+	// checking round-trip byte equality.
+	var mu sync.Mutex
+	var writeSizes []int
+	t.serverCfg.SetOnWrite(func(n int) {
+		mu.Lock()
+		writeSizes = append(writeSizes, n)
+		mu.Unlock()
+	})
+
+	contents := make([]byte, size)
+	for i := range contents {
+		contents[i] = byte(i)
+	}
+
+	p := path.Join(t.Dir, "large")
+	err := ioutil.WriteFile(p, contents, 0400)
+	AssertEq(nil, err)
+
+	readBack, err := ioutil.ReadFile(p)
+	AssertEq(nil, err)
+	AssertEq(len(contents), len(readBack))
+	ExpectTrue(bytes.Equal(contents, readBack), "contents differ after round trip")
+
+	mu.Lock()
+	defer mu.Unlock()
+	AssertTrue(len(writeSizes) > 0, "no writes observed")
+
+	maxWrite := 0
+	for _, n := range writeSizes {
+		if n > maxWrite {
+			maxWrite = n
+		}
+	}
+
+	ExpectTrue(
+		maxWrite == 128*1024 || maxWrite >= 1024*1024,
+		"largest observed kernel write was %d bytes; expected either the "+
+			"128 KiB fallback or a negotiated chunk of at least 1 MiB",
+		maxWrite)
+}
+
 func (t *StressTest) MkdirInParallel() {
 	fusetesting.RunMkdirInParallelTest(t.ctx, t.Dir)
 }
@@ -0,0 +1,203 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/timeutil"
+
+	"github.com/ipv1337/gcsfuse/fs"
+	"github.com/ipv1337/gcsfuse/prefetch"
+	"github.com/ipv1337/gcsfuse/ratelimit"
+)
+
+func usage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(
+			os.Stderr,
+			"Usage: %s [flags] bucket mount_point\n\n",
+			os.Args[0])
+
+		fs.PrintDefaults()
+	}
+}
+
+// mount parses args according to flagSet, opens the named bucket using conn,
+// and mounts it at the named mount point. args must consist of a bucket name
+// followed by a mount point, after any flags.
+func mount(
+	ctx context.Context,
+	args []string,
+	flagSet *flag.FlagSet,
+	conn gcs.Conn) (mfs *fuse.MountedFileSystem, err error) {
+	flags := populateFlags(flagSet)
+
+	if flagSet.Usage == nil {
+		flagSet.Usage = usage(flagSet)
+	}
+
+	if err = flagSet.Parse(args); err != nil {
+		return
+	}
+
+	positional := flagSet.Args()
+	if len(positional) != 2 {
+		err = fmt.Errorf(
+			"usage: %s [flags] bucket mount_point", os.Args[0])
+		return
+	}
+
+	bucketName := positional[0]
+	mountPoint := positional[1]
+
+	bucket, err := conn.OpenBucket(ctx, bucketName)
+	if err != nil {
+		err = fmt.Errorf("OpenBucket: %v", err)
+		return
+	}
+
+	// Wrap the bucket so that reads and writes are subject to the configured
+	// throughput caps.
+	bucket = ratelimit.ThrottleBucket(
+		bucket,
+		flags.MaxReadBytesPerSec,
+		flags.MaxWriteBytesPerSec)
+
+	// Serve the rate-limit debug endpoint on its own mux, started only if
+	// the caller asked for it; otherwise RegisterDebugHandler's work would
+	// be unreachable dead code.
+	if flags.DebugAddr != "" {
+		debugMux := http.NewServeMux()
+		ratelimit.RegisterDebugHandler(debugMux, "/ratestats", bucket)
+
+		listener, lerr := net.Listen("tcp", flags.DebugAddr)
+		if lerr != nil {
+			err = fmt.Errorf("listening on %s for debug server: %v", flags.DebugAddr, lerr)
+			return
+		}
+
+		go http.Serve(listener, debugMux)
+	}
+
+	prefetchPolicy, err := prefetch.ParsePolicy(flags.Prefetch)
+	if err != nil {
+		err = fmt.Errorf("prefetch.ParsePolicy: %v", err)
+		return
+	}
+
+	prefetcher, err := prefetch.NewPrefetcher(bucket, prefetchPolicy, 0)
+	if err != nil {
+		err = fmt.Errorf("prefetch.NewPrefetcher: %v", err)
+		return
+	}
+
+	// Manifest-driven warming happens synchronously so that the cache is hot
+	// by the time callers can observe the mount.
+	if err = prefetcher.Warm(ctx); err != nil {
+		err = fmt.Errorf("prefetcher.Warm: %v", err)
+		return
+	}
+
+	serverCfg := &fs.ServerConfig{
+		Clock:  timeutil.RealClock(),
+		Bucket: bucket,
+	}
+	serverCfg.SetPrefetcher(prefetcher)
+
+	fileSystem, err := fs.NewFileSystem(serverCfg)
+	if err != nil {
+		err = fmt.Errorf("fs.NewFileSystem: %v", err)
+		return
+	}
+
+	maxWriteKB := flags.MaxWriteKB
+	if maxWriteKB <= 0 {
+		maxWriteKB = 128
+	}
+
+	mountCfg := &fuse.MountConfig{
+		Options: map[string]string{
+			"max_write": fmt.Sprintf("%d", maxWriteKB*1024),
+
+			// Ask the kernel to read ahead by the same amount we negotiate
+			// for writes, so large sequential reads see similarly few round
+			// trips. fuse.MountConfig has no MaxReadahead field; like
+			// max_write, this is negotiated through the mount options.
+			"max_readahead": fmt.Sprintf("%d", maxWriteKB*1024),
+
+			"fsname": escapeFsNameOption(bucketName),
+		},
+	}
+
+	if flags.DirectMount {
+		// jacobsa/fuse has no public way to accept an already-established
+		// kernel connection, so there's no way to have fuse.Mount itself
+		// skip shelling out to fusermount(1) below. Still, open /dev/fuse
+		// directly here so that containers and minimal images missing it
+		// (or lacking permission to use it) fail fast with a clear error
+		// instead of an opaque one from fusermount.
+		var devFuse *os.File
+		devFuse, err = openDevFuse()
+		if err != nil {
+			err = fmt.Errorf("direct-mount requested but unusable: %v", err)
+			return
+		}
+		devFuse.Close()
+	}
+
+	mfs, err = fuse.Mount(
+		mountPoint,
+		fuseutil.NewFileSystemServer(fileSystem),
+		mountCfg)
+	if err != nil {
+		err = fmt.Errorf("fuse.Mount: %v", err)
+		return
+	}
+
+	return
+}
+
+func main() {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+
+	ctx := context.Background()
+	flagSet := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+	conn, err := getConn()
+	if err != nil {
+		log.Fatalf("getConn: %v", err)
+	}
+
+	mfs, err := mount(ctx, os.Args[1:], flagSet, conn)
+	if err != nil {
+		log.Fatalf("mount: %v", err)
+	}
+
+	if err = mfs.Join(ctx); err != nil {
+		log.Fatalf("Join: %v", err)
+	}
+}
@@ -19,6 +19,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"path"
 	"strings"
@@ -175,3 +177,83 @@ func (t *MountTest) BasicUsage() {
 	err = mfs.Join(t.ctx)
 	AssertEq(nil, err)
 }
+
+func (t *MountTest) DirectMount_BucketNameWithComma() {
+	var err error
+	const bucketName = "weird,name"
+
+	bucket, err := t.conn.OpenBucket(t.ctx, bucketName)
+	AssertEq(nil, err)
+
+	mfs, err := t.mount([]string{
+		"--direct-mount",
+		bucket.Name(),
+		t.dir,
+	})
+	AssertEq(nil, err)
+
+	err = t.unmount()
+	AssertEq(nil, err)
+
+	err = mfs.Join(t.ctx)
+	AssertEq(nil, err)
+}
+
+func (t *MountTest) DebugAddrFlag_ServesRatestats() {
+	var err error
+
+	// Find a free port to ask gcsfuse to bind the debug server to.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	AssertEq(nil, err)
+	addr := l.Addr().String()
+	AssertEq(nil, l.Close())
+
+	bucket, err := t.conn.OpenBucket(t.ctx, "some_bucket")
+	AssertEq(nil, err)
+
+	mfs, err := t.mount([]string{
+		fmt.Sprintf("--debug-addr=%s", addr),
+		bucket.Name(),
+		t.dir,
+	})
+	AssertEq(nil, err)
+
+	// Give the background listener a moment to come up.
+	var resp *http.Response
+	for i := 0; i < 100; i++ {
+		resp, err = http.Get(fmt.Sprintf("http://%s/ratestats", addr))
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	AssertEq(nil, err)
+	ExpectEq(200, resp.StatusCode)
+	resp.Body.Close()
+
+	err = t.unmount()
+	AssertEq(nil, err)
+
+	err = mfs.Join(t.ctx)
+	AssertEq(nil, err)
+}
+
+func (t *MountTest) MaxWriteKBFlag_LargeValueMounts() {
+	var err error
+
+	bucket, err := t.conn.OpenBucket(t.ctx, "some_bucket")
+	AssertEq(nil, err)
+
+	mfs, err := t.mount([]string{
+		"--max-write-kb=2048",
+		bucket.Name(),
+		t.dir,
+	})
+	AssertEq(nil, err)
+
+	err = t.unmount()
+	AssertEq(nil, err)
+
+	err = mfs.Join(t.ctx)
+	AssertEq(nil, err)
+}
@@ -0,0 +1,93 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prefetch schedules background GCS reads of objects likely to be
+// accessed soon, similar in spirit to the priority-chunk streaming used by
+// lazily-pulled container image formats.
+package prefetch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Kind identifies which prefetch policy is in effect.
+type Kind int
+
+const (
+	// None performs no prefetching; this is the default.
+	None Kind = iota
+
+	// Readahead issues concurrent ranged GETs for the N chunks following the
+	// one a caller just read.
+	Readahead
+
+	// Manifest reads a landmark list of (object, byte range) entries at
+	// mount time and prefetches all of them before the mount completes.
+	Manifest
+
+	// AccessLog appends (name, offset, len, t) tuples for every read, so
+	// that a later run can replay them as a manifest.
+	AccessLog
+)
+
+// Policy is a parsed --prefetch flag value.
+type Policy struct {
+	Kind Kind
+
+	// Set when Kind == Readahead: the number of chunks to read ahead.
+	ReadaheadChunks int
+
+	// Set when Kind == Manifest or Kind == AccessLog: the path to the
+	// landmark list or access log, respectively.
+	Path string
+}
+
+// ParsePolicy parses the argument to --prefetch. The empty string and
+// "none" both yield the None policy.
+func ParsePolicy(spec string) (p Policy, err error) {
+	if spec == "" || spec == "none" {
+		p = Policy{Kind: None}
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(spec, "readahead="):
+		var n int
+		n, err = strconv.Atoi(strings.TrimPrefix(spec, "readahead="))
+		if err != nil {
+			err = fmt.Errorf("invalid readahead chunk count in %q: %v", spec, err)
+			return
+		}
+
+		if n <= 0 {
+			err = fmt.Errorf("readahead chunk count must be positive, got %d", n)
+			return
+		}
+
+		p = Policy{Kind: Readahead, ReadaheadChunks: n}
+
+	case strings.HasPrefix(spec, "manifest="):
+		p = Policy{Kind: Manifest, Path: strings.TrimPrefix(spec, "manifest=")}
+
+	case strings.HasPrefix(spec, "access-log="):
+		p = Policy{Kind: AccessLog, Path: strings.TrimPrefix(spec, "access-log=")}
+
+	default:
+		err = fmt.Errorf("unrecognized --prefetch policy: %q", spec)
+	}
+
+	return
+}
@@ -0,0 +1,371 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefetch
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+)
+
+// DefaultChunkSize is used by the Readahead policy when the caller doesn't
+// override it.
+const DefaultChunkSize = 4 * 1024 * 1024
+
+// Prefetcher schedules background reads of a bucket's objects according to
+// a Policy, landing the results in a temp-file cache keyed by object name.
+type Prefetcher struct {
+	bucket    gcs.Bucket
+	policy    Policy
+	chunkSize int64
+
+	mu          sync.Mutex
+	inFlight    map[string]bool
+	cache       map[string]*os.File
+	complete    map[string]bool
+	accessLogMu sync.Mutex
+	accessLog   *os.File
+}
+
+// NewPrefetcher returns a Prefetcher that reads ahead from bucket according
+// to policy. chunkSize governs the granularity of Readahead prefetches; if
+// non-positive, DefaultChunkSize is used.
+func NewPrefetcher(
+	bucket gcs.Bucket,
+	policy Policy,
+	chunkSize int64) (pf *Prefetcher, err error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	pf = &Prefetcher{
+		bucket:    bucket,
+		policy:    policy,
+		chunkSize: chunkSize,
+		inFlight:  make(map[string]bool),
+		cache:     make(map[string]*os.File),
+		complete:  make(map[string]bool),
+	}
+
+	if policy.Kind == AccessLog {
+		pf.accessLog, err = os.OpenFile(
+			policy.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			err = fmt.Errorf("opening access log %q: %v", policy.Path, err)
+			return
+		}
+	}
+
+	return
+}
+
+// Warm eagerly prefetches every entry in the policy's landmark manifest. It
+// is a no-op unless the policy is Manifest, and is meant to be called once,
+// synchronously, before a mount completes.
+func (pf *Prefetcher) Warm(ctx context.Context) (err error) {
+	if pf.policy.Kind != Manifest {
+		return
+	}
+
+	landmarks, err := readManifest(pf.policy.Path)
+	if err != nil {
+		err = fmt.Errorf("reading manifest %q: %v", pf.policy.Path, err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(landmarks))
+
+	for _, lm := range landmarks {
+		lm := lm
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Only a landmark that covers the whole object, confirmed
+			// against its real size, lets TryReadCachedFile later trust the
+			// cache as a substitute for downloading it; a partial range
+			// would otherwise be served as if it were the complete object.
+			attrs, serr := pf.bucket.StatObject(
+				ctx, &gcs.StatObjectRequest{Name: lm.Name})
+			if serr != nil {
+				errs <- serr
+				return
+			}
+
+			if ferr := pf.fetchRange(ctx, lm.Name, lm.Offset, lm.Length); ferr != nil {
+				errs <- ferr
+				return
+			}
+
+			if lm.Offset == 0 && lm.Length >= int64(attrs.Size) {
+				pf.markComplete(lm.Name)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for e := range errs {
+		if err == nil {
+			err = e
+		}
+	}
+
+	return
+}
+
+// OnRead notifies the prefetcher that a caller just read object name at
+// offset. Under the Readahead policy this schedules a background fetch of
+// the following chunks; under AccessLog it records the access. It never
+// blocks the caller.
+func (pf *Prefetcher) OnRead(name string, offset int64, length int) {
+	if pf.accessLog != nil {
+		pf.logAccess(name, offset, length)
+	}
+
+	if pf.policy.Kind != Readahead {
+		return
+	}
+
+	start := offset + int64(length)
+	length64 := pf.chunkSize * int64(pf.policy.ReadaheadChunks)
+
+	key := fmt.Sprintf("%s:%d", name, start)
+
+	pf.mu.Lock()
+	if pf.inFlight[key] {
+		pf.mu.Unlock()
+		return
+	}
+	pf.inFlight[key] = true
+	pf.mu.Unlock()
+
+	go func() {
+		defer func() {
+			pf.mu.Lock()
+			delete(pf.inFlight, key)
+			pf.mu.Unlock()
+		}()
+
+		_ = pf.fetchRange(context.Background(), name, start, length64)
+	}()
+}
+
+// fetchRange reads [offset, offset+length) of the named object into the
+// temp-file cache, creating the object's cache entry on first use (see
+// cacheFile).
+func (pf *Prefetcher) fetchRange(
+	ctx context.Context,
+	name string,
+	offset int64,
+	length int64) (err error) {
+	if length <= 0 {
+		return
+	}
+
+	limit := uint64(offset + length)
+	req := &gcs.ReadObjectRequest{
+		Name: name,
+		Range: &gcs.ByteRange{
+			Start: uint64(offset),
+			Limit: limit,
+		},
+	}
+
+	rc, err := pf.bucket.NewReader(ctx, req)
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+
+	f, err := pf.cacheFile(name)
+	if err != nil {
+		return
+	}
+
+	buf := make([]byte, 64*1024)
+	at := offset
+	for {
+		var n int
+		n, err = rc.Read(buf)
+		if n > 0 {
+			if _, werr := f.WriteAt(buf[:n], at); werr != nil {
+				err = werr
+				return
+			}
+			at += int64(n)
+		}
+
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			break
+		}
+	}
+
+	return
+}
+
+// TryReadCached returns the bytes of the given range of name if they have
+// already been prefetched into the cache, and false otherwise. It never
+// issues a GCS request.
+func (pf *Prefetcher) TryReadCached(
+	name string, offset int64, length int) (data []byte, ok bool) {
+	pf.mu.Lock()
+	f, ok := pf.cache[name]
+	pf.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	data = make([]byte, length)
+	n, err := f.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return nil, false
+	}
+
+	return data[:n], true
+}
+
+// TryReadCachedFile returns the entirety of name's contents if they have
+// already been prefetched into the cache in full, and false otherwise. It
+// never issues a GCS request. Callers that want to serve an open() from a
+// warmed cache rather than re-downloading the object use this.
+//
+// This deliberately requires more than a cache entry existing: Readahead's
+// fetchRange calls populate the same per-name cache file with only partial,
+// possibly non-contiguous ranges, so an entry's presence alone doesn't mean
+// it holds the whole object. Only markComplete's callers (Warm, for
+// landmarks confirmed to cover the full object) may vouch for that.
+func (pf *Prefetcher) TryReadCachedFile(name string) (data []byte, ok bool) {
+	pf.mu.Lock()
+	f, cached := pf.cache[name]
+	complete := pf.complete[name]
+	pf.mu.Unlock()
+	if !cached || !complete {
+		return nil, false
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, false
+	}
+
+	return pf.TryReadCached(name, 0, int(fi.Size()))
+}
+
+// markComplete records that name's entire contents are now present in the
+// cache.
+func (pf *Prefetcher) markComplete(name string) {
+	pf.mu.Lock()
+	pf.complete[name] = true
+	pf.mu.Unlock()
+}
+
+// cacheFile returns the temp file backing the prefetch cache for name,
+// creating it on first use.
+func (pf *Prefetcher) cacheFile(name string) (f *os.File, err error) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	if f, ok := pf.cache[name]; ok {
+		return f, nil
+	}
+
+	f, err = ioutil.TempFile("", "gcsfuse-prefetch-")
+	if err != nil {
+		return
+	}
+
+	pf.cache[name] = f
+	return
+}
+
+func (pf *Prefetcher) logAccess(name string, offset int64, length int) {
+	pf.accessLogMu.Lock()
+	defer pf.accessLogMu.Unlock()
+
+	fmt.Fprintf(
+		pf.accessLog,
+		"%s\t%d\t%d\t%d\n",
+		name, offset, length, time.Now().Unix())
+}
+
+// landmark is one entry of a prefetch manifest: an object name and the byte
+// range within it that should be warmed.
+type landmark struct {
+	Name   string
+	Offset int64
+	Length int64
+}
+
+// readManifest parses a newline-delimited landmark list of the form
+// "name\toffset\tlength", as written by the AccessLog policy.
+func readManifest(path string) (landmarks []landmark, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) < 3 {
+			err = fmt.Errorf("malformed manifest line: %q", line)
+			return
+		}
+
+		var offset, length int64
+		offset, err = strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			err = fmt.Errorf("malformed offset in line %q: %v", line, err)
+			return
+		}
+
+		length, err = strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			err = fmt.Errorf("malformed length in line %q: %v", line, err)
+			return
+		}
+
+		landmarks = append(landmarks, landmark{
+			Name:   fields[0],
+			Offset: offset,
+			Length: length,
+		})
+	}
+
+	err = scanner.Err()
+	return
+}
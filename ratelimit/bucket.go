@@ -0,0 +1,121 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"io"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+)
+
+// throttledBucket wraps a gcs.Bucket, capping the rate at which bytes are
+// read from and written to the underlying bucket.
+type throttledBucket struct {
+	gcs.Bucket
+
+	readMonitor  *Monitor
+	writeMonitor *Monitor
+}
+
+// ThrottleBucket wraps b so that object reads and writes are capped at
+// maxReadBytesPerSec and maxWriteBytesPerSec bytes per second,
+// respectively. A non-positive limit leaves the corresponding direction
+// unthrottled; b is returned unwrapped if both limits are non-positive.
+func ThrottleBucket(
+	b gcs.Bucket,
+	maxReadBytesPerSec int64,
+	maxWriteBytesPerSec int64) gcs.Bucket {
+	if maxReadBytesPerSec <= 0 && maxWriteBytesPerSec <= 0 {
+		return b
+	}
+
+	return &throttledBucket{
+		Bucket:       b,
+		readMonitor:  NewMonitor(maxReadBytesPerSec),
+		writeMonitor: NewMonitor(maxWriteBytesPerSec),
+	}
+}
+
+func (b *throttledBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	rc, err = b.Bucket.NewReader(ctx, req)
+	if err != nil {
+		return
+	}
+
+	rc = &throttledReadCloser{
+		ReadCloser: rc,
+		monitor:    b.readMonitor,
+	}
+
+	return
+}
+
+func (b *throttledBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	if req.Contents != nil {
+		req.Contents = &throttledReader{
+			Reader:  req.Contents,
+			monitor: b.writeMonitor,
+		}
+	}
+
+	o, err = b.Bucket.CreateObject(ctx, req)
+	return
+}
+
+// ReadStats reports the current state of the read-side monitor.
+func (b *throttledBucket) ReadStats() Stats {
+	return b.readMonitor.Stats()
+}
+
+// WriteStats reports the current state of the write-side monitor.
+func (b *throttledBucket) WriteStats() Stats {
+	return b.writeMonitor.Stats()
+}
+
+const burstBytes = 1 << 20
+
+// throttledReadCloser wraps an io.ReadCloser, blocking in Read until the
+// monitor's rate limit allows the requested number of bytes through.
+type throttledReadCloser struct {
+	io.ReadCloser
+	monitor *Monitor
+}
+
+func (rc *throttledReadCloser) Read(p []byte) (n int, err error) {
+	allowed := rc.monitor.WaitForBudget(len(p), burstBytes)
+	n, err = rc.ReadCloser.Read(p[:allowed])
+	rc.monitor.Update(n)
+	return
+}
+
+// throttledReader is the analogous wrapper for the io.Reader supplying the
+// contents of an object being uploaded.
+type throttledReader struct {
+	io.Reader
+	monitor *Monitor
+}
+
+func (r *throttledReader) Read(p []byte) (n int, err error) {
+	allowed := r.monitor.WaitForBudget(len(p), burstBytes)
+	n, err = r.Reader.Read(p[:allowed])
+	r.monitor.Update(n)
+	return
+}
@@ -0,0 +1,80 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/timeutil"
+	. "github.com/jacobsa/ogletest"
+
+	"github.com/ipv1337/gcsfuse/ratelimit"
+)
+
+func TestRatelimit(t *testing.T) { RunTests(t) }
+
+type ThrottleBucketTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	bucket gcs.Bucket
+}
+
+func init() { RegisterTestSuite(&ThrottleBucketTest{}) }
+
+func (t *ThrottleBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+
+	conn := gcsfake.NewConn(&t.clock)
+	bucket, err := conn.OpenBucket(ti.Ctx, "some_bucket")
+	AssertEq(nil, err)
+	t.bucket = bucket
+}
+
+func (t *ThrottleBucketTest) CreateObject_RespectsWriteRateLimit() {
+	const limitBytesPerSec = 64 * 1024
+	const tolerance = 1.5 // allow some slack for scheduling jitter
+
+	throttled := ratelimit.ThrottleBucket(t.bucket, 0, limitBytesPerSec)
+
+	contents := make([]byte, 4*limitBytesPerSec)
+	for i := range contents {
+		contents[i] = byte(i)
+	}
+
+	startTime := time.Now()
+	_, err := throttled.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "ratelimit_test_object",
+			Contents: bytes.NewReader(contents),
+		})
+	AssertEq(nil, err)
+
+	elapsed := time.Since(startTime).Seconds()
+	observedRate := float64(len(contents)) / elapsed
+
+	ExpectTrue(
+		observedRate < limitBytesPerSec*tolerance,
+		"observed rate %.0f B/s exceeds limit %.0f B/s by more than the "+
+			"allowed tolerance",
+		observedRate, float64(limitBytesPerSec))
+}
@@ -0,0 +1,51 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// StatsSource is satisfied by any gcs.Bucket returned from ThrottleBucket;
+// callers that need to report rate-limit stats (the debug HTTP handler, the
+// fs package's ratestats xattr) type-assert a bucket against it.
+type StatsSource interface {
+	ReadStats() Stats
+	WriteStats() Stats
+}
+
+// RegisterDebugHandler installs a handler at the given path on mux that
+// dumps, in plain text, the bytes transferred, the current (exponentially
+// smoothed) rate, and the peak rate observed on b for reads and writes. No
+// long-run average rate is reported. If b was not created with ThrottleBucket (and so does not
+// expose stats), the handler reports that rate limiting is disabled.
+func RegisterDebugHandler(mux *http.ServeMux, path string, b interface{}) {
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		src, ok := b.(StatsSource)
+		if !ok {
+			fmt.Fprintln(w, "rate limiting disabled")
+			return
+		}
+
+		rs := src.ReadStats()
+		ws := src.WriteStats()
+
+		fmt.Fprintf(w, "read:  bytes=%d rate=%.0f B/s peak=%.0f B/s\n",
+			rs.Bytes, rs.CurrentRate, rs.PeakRate)
+		fmt.Fprintf(w, "write: bytes=%d rate=%.0f B/s peak=%.0f B/s\n",
+			ws.Bytes, ws.CurrentRate, ws.PeakRate)
+	})
+}
@@ -0,0 +1,154 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ratelimit provides a simple token-bucket style throughput monitor
+// and limiter, used to cap the rate at which gcsfuse moves bytes to and
+// from GCS.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// alpha is the smoothing factor used for the exponential moving average of
+// the observed transfer rate. A value of 0.1 gives roughly a one second
+// window at the sampling rates Update is typically called with.
+const alpha = 0.1
+
+// Monitor tracks the throughput of a single direction (read or write) of
+// traffic to GCS, and optionally limits it to a configured rate.
+//
+// It is safe for concurrent use by multiple goroutines.
+type Monitor struct {
+	mu sync.Mutex
+
+	// limit is the configured cap in bytes per second. Zero means unlimited.
+	limit int64
+
+	active  bool
+	start   time.Time
+	last    time.Time
+	bytes   int64
+	samples int64
+	rEMA    float64
+	rPeak   float64
+}
+
+// NewMonitor returns a Monitor that caps throughput at limitBytesPerSec
+// bytes per second. A non-positive limit means unlimited; Update is still
+// tracked so current/average/peak rates remain available.
+func NewMonitor(limitBytesPerSec int64) *Monitor {
+	return &Monitor{limit: limitBytesPerSec}
+}
+
+// Update records that n bytes were just transferred.
+func (m *Monitor) Update(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if !m.active {
+		m.active = true
+		m.start = now
+		m.last = now
+	}
+
+	dt := now.Sub(m.last).Seconds()
+	m.last = now
+	m.bytes += int64(n)
+	m.samples++
+
+	if dt > 0 {
+		rSample := float64(n) / dt
+		m.rEMA = alpha*rSample + (1-alpha)*m.rEMA
+		if m.rEMA > m.rPeak {
+			m.rPeak = m.rEMA
+		}
+	}
+}
+
+// Limit returns the number of bytes, at most want, that the caller may
+// transfer right now without exceeding the configured rate. If burst is
+// positive, the result is also capped at burst bytes; no allowance beyond
+// the steady-state one is granted. If the limiter is unlimited (limit <= 0),
+// Limit always returns want.
+func (m *Monitor) Limit(want, burst int) int {
+	if m.limit <= 0 {
+		return want
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.active {
+		m.active = true
+		m.start = time.Now()
+		m.last = m.start
+	}
+
+	elapsed := time.Since(m.start).Seconds()
+	allowed := int64(float64(m.limit)*elapsed) - m.bytes
+	if allowed < 0 {
+		allowed = 0
+	}
+
+	if burst > 0 && allowed > int64(burst) {
+		allowed = int64(burst)
+	}
+
+	if allowed > int64(want) {
+		allowed = int64(want)
+	}
+
+	return int(allowed)
+}
+
+// WaitForBudget blocks until at least min(want, burst) bytes are available
+// to transfer, then returns the number of bytes (at most want) the caller
+// may transfer.
+func (m *Monitor) WaitForBudget(want, burst int) int {
+	need := want
+	if burst > 0 && burst < need {
+		need = burst
+	}
+
+	for {
+		allowed := m.Limit(want, burst)
+		if allowed >= need {
+			return allowed
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Stats is a snapshot of a Monitor's observed throughput.
+type Stats struct {
+	Bytes       int64
+	CurrentRate float64
+	PeakRate    float64
+}
+
+// Stats returns a snapshot of the monitor's current state.
+func (m *Monitor) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return Stats{
+		Bytes:       m.bytes,
+		CurrentRate: m.rEMA,
+		PeakRate:    m.rPeak,
+	}
+}